@@ -3,25 +3,38 @@ package main
 /*
 #include <stdlib.h>
 #include <stdint.h>
+
+typedef void (*backup_callback_t)(void *user_data, const char *chunk, int chunk_len);
+
+static inline void call_backup_callback(backup_callback_t cb, void *user_data, const char *chunk, int chunk_len) {
+	cb(user_data, chunk, chunk_len);
+}
 */
 import "C"
 
 import (
+	"bytes"
+	"encoding/base64"
 	"encoding/binary"
+	"encoding/json"
 	"errors"
+	"io"
 	"os"
 	"sync"
+	"time"
 	"unsafe"
 
 	"github.com/dgraph-io/badger/v4"
+	badgeroptions "github.com/dgraph-io/badger/v4/options"
 )
 
 var (
-	handleMu  sync.RWMutex
-	handles           = make(map[uintptr]*badger.DB)
-	nextID    uintptr = 1
-	errorMu   sync.Mutex
-	lastError string
+	handleMu       sync.RWMutex
+	handles                = make(map[uintptr]*badger.DB)
+	managedHandles         = make(map[uintptr]bool)
+	nextID         uintptr = 1
+	errorMu        sync.Mutex
+	lastError      string
 )
 
 func setError(err error) C.int {
@@ -35,12 +48,13 @@ func setError(err error) C.int {
 	return 0
 }
 
-func storeHandle(db *badger.DB) uintptr {
+func storeHandle(db *badger.DB, managed bool) uintptr {
 	handleMu.Lock()
 	defer handleMu.Unlock()
 	id := nextID
 	nextID++
 	handles[id] = db
+	managedHandles[id] = managed
 	return id
 }
 
@@ -54,62 +68,556 @@ func getHandle(id uintptr) (*badger.DB, error) {
 	return db, nil
 }
 
+// isManagedHandle reports whether the DB behind id was opened in managed
+// mode (OpenWithOptions with "managed": true), which is a prerequisite for
+// TxnSetReadTs/TxnCommitTs.
+func isManagedHandle(id uintptr) bool {
+	handleMu.RLock()
+	defer handleMu.RUnlock()
+	return managedHandles[id]
+}
+
+// requireUnmanagedHandle rejects an operation against a managed-mode DB with
+// a plain error instead of letting it reach badger, which panics outright on
+// db.Update/db.NewTransaction calls once opt.managedTxns is set. Managed mode
+// is Txn-handle-only: writes and scans must go through TxnBegin/TxnSetReadTs.
+func requireUnmanagedHandle(dbHandle uintptr, fn string) error {
+	if isManagedHandle(dbHandle) {
+		return errors.New(fn + " cannot be used on a DB opened with managed mode (OpenWithOptions \"managed\": true); use the Txn* handle API instead")
+	}
+	return nil
+}
+
 func deleteHandle(id uintptr) {
 	handleMu.Lock()
 	defer handleMu.Unlock()
 	delete(handles, id)
+	delete(managedHandles, id)
 }
 
-//export Open
-func Open(path *C.char, inMemory C.int) C.uintptr_t {
-	goPath := C.GoString(path)
-	if inMemory != 0 {
-		goPath = ""
+// iterHandle pairs a long-lived read-only txn with the cursor reading
+// through it, so a single prefix scan can be driven across many FFI calls
+// instead of materializing the whole range up front.
+type iterHandle struct {
+	dbHandle   uintptr
+	txn        *badger.Txn
+	it         *badger.Iterator
+	prefix     []byte
+	upperBound []byte
+	reverse    bool
+	keysOnly   bool
+}
+
+var (
+	iterMu      sync.RWMutex
+	iterHandles         = make(map[uintptr]*iterHandle)
+	nextIterID  uintptr = 1
+)
+
+func storeIter(ih *iterHandle) uintptr {
+	iterMu.Lock()
+	defer iterMu.Unlock()
+	id := nextIterID
+	nextIterID++
+	iterHandles[id] = ih
+	return id
+}
+
+func getIter(id uintptr) (*iterHandle, error) {
+	iterMu.RLock()
+	defer iterMu.RUnlock()
+	ih, ok := iterHandles[id]
+	if !ok {
+		return nil, errors.New("invalid iterator handle")
+	}
+	return ih, nil
+}
+
+func deleteIter(id uintptr) {
+	iterMu.Lock()
+	defer iterMu.Unlock()
+	delete(iterHandles, id)
+}
+
+// closeItersForHandle discards every iterator still open against dbHandle,
+// so closing the DB cannot leave a dangling txn behind.
+func closeItersForHandle(dbHandle uintptr) {
+	iterMu.Lock()
+	defer iterMu.Unlock()
+	for id, ih := range iterHandles {
+		if ih.dbHandle != dbHandle {
+			continue
+		}
+		ih.it.Close()
+		ih.txn.Discard()
+		delete(iterHandles, id)
+	}
+}
+
+// snapHandle pins a read-only txn so repeated Get/Scan calls observe the
+// same consistent point-in-time view of the DB.
+type snapHandle struct {
+	dbHandle uintptr
+	txn      *badger.Txn
+}
+
+var (
+	snapMu      sync.RWMutex
+	snapHandles         = make(map[uintptr]*snapHandle)
+	nextSnapID  uintptr = 1
+)
+
+func storeSnap(sh *snapHandle) uintptr {
+	snapMu.Lock()
+	defer snapMu.Unlock()
+	id := nextSnapID
+	nextSnapID++
+	snapHandles[id] = sh
+	return id
+}
+
+func getSnap(id uintptr) (*snapHandle, error) {
+	snapMu.RLock()
+	defer snapMu.RUnlock()
+	sh, ok := snapHandles[id]
+	if !ok {
+		return nil, errors.New("invalid snapshot handle")
+	}
+	return sh, nil
+}
+
+func deleteSnap(id uintptr) {
+	snapMu.Lock()
+	defer snapMu.Unlock()
+	delete(snapHandles, id)
+}
+
+// closeSnapsForHandle discards every snapshot still open against dbHandle,
+// so closing the DB cannot leave a dangling txn behind.
+func closeSnapsForHandle(dbHandle uintptr) {
+	snapMu.Lock()
+	defer snapMu.Unlock()
+	for id, sh := range snapHandles {
+		if sh.dbHandle != dbHandle {
+			continue
+		}
+		sh.txn.Discard()
+		delete(snapHandles, id)
+	}
+}
+
+// txnHandle backs an interactive read/write transaction spanning several
+// FFI calls: TxnBegin creates it, TxnSet/TxnGet/TxnDelete operate against
+// it, and TxnCommit/TxnDiscard end its lifetime.
+type txnHandle struct {
+	dbHandle uintptr
+	db       *badger.DB
+	txn      *badger.Txn
+	update   bool
+	used     bool
+}
+
+var (
+	txnMu      sync.RWMutex
+	txnHandles         = make(map[uintptr]*txnHandle)
+	nextTxnID  uintptr = 1
+)
+
+func storeTxn(th *txnHandle) uintptr {
+	txnMu.Lock()
+	defer txnMu.Unlock()
+	id := nextTxnID
+	nextTxnID++
+	txnHandles[id] = th
+	return id
+}
+
+func getTxn(id uintptr) (*txnHandle, error) {
+	txnMu.RLock()
+	defer txnMu.RUnlock()
+	th, ok := txnHandles[id]
+	if !ok {
+		return nil, errors.New("invalid transaction handle")
+	}
+	return th, nil
+}
+
+func deleteTxn(id uintptr) {
+	txnMu.Lock()
+	defer txnMu.Unlock()
+	delete(txnHandles, id)
+}
+
+// closeTxnsForHandle discards every transaction still open against
+// dbHandle, so closing the DB cannot leave a dangling txn behind.
+func closeTxnsForHandle(dbHandle uintptr) {
+	txnMu.Lock()
+	defer txnMu.Unlock()
+	for id, th := range txnHandles {
+		if th.dbHandle != dbHandle {
+			continue
+		}
+		if th.txn != nil {
+			th.txn.Discard()
+		}
+		delete(txnHandles, id)
+	}
+}
+
+// mergeCompactionInterval is how often a registered merge operator folds
+// pending Add()s into the stored value; see badger.DB.GetMergeOperator.
+const mergeCompactionInterval = 200 * time.Millisecond
+
+// mergeHandle keeps a registered merge operator alive across FFI calls so
+// repeated MergeAdd/MergeGet calls target the same background compaction.
+type mergeHandle struct {
+	dbHandle uintptr
+	op       *badger.MergeOperator
+}
+
+var (
+	mergeMu      sync.RWMutex
+	mergeHandles         = make(map[uintptr]*mergeHandle)
+	nextMergeID  uintptr = 1
+)
+
+func storeMerge(mh *mergeHandle) uintptr {
+	mergeMu.Lock()
+	defer mergeMu.Unlock()
+	id := nextMergeID
+	nextMergeID++
+	mergeHandles[id] = mh
+	return id
+}
+
+func getMerge(id uintptr) (*mergeHandle, error) {
+	mergeMu.RLock()
+	defer mergeMu.RUnlock()
+	mh, ok := mergeHandles[id]
+	if !ok {
+		return nil, errors.New("invalid merge handle")
+	}
+	return mh, nil
+}
+
+func deleteMerge(id uintptr) {
+	mergeMu.Lock()
+	defer mergeMu.Unlock()
+	delete(mergeHandles, id)
+}
+
+// closeMergesForHandle stops every merge operator still registered against
+// dbHandle, so closing the DB cannot leave its compaction goroutine behind.
+func closeMergesForHandle(dbHandle uintptr) {
+	mergeMu.Lock()
+	defer mergeMu.Unlock()
+	for id, mh := range mergeHandles {
+		if mh.dbHandle != dbHandle {
+			continue
+		}
+		mh.op.Stop()
+		delete(mergeHandles, id)
+	}
+}
+
+// Built-in merge reducers selectable by MergeRegister's opCode.
+const (
+	mergeOpAppendBytes int = 0
+	mergeOpUint64Add   int = 1
+	mergeOpMax         int = 2
+	mergeOpMin         int = 3
+	mergeOpSetUnion    int = 4
+)
+
+func mergeFuncForOpCode(opCode int) (badger.MergeFunc, error) {
+	switch opCode {
+	case mergeOpAppendBytes:
+		return mergeAppendBytes, nil
+	case mergeOpUint64Add:
+		return mergeUint64Add, nil
+	case mergeOpMax:
+		return mergeUint64Max, nil
+	case mergeOpMin:
+		return mergeUint64Min, nil
+	case mergeOpSetUnion:
+		return mergeSetUnion, nil
+	default:
+		return nil, errors.New("unknown merge operator code")
+	}
+}
+
+func mergeAppendBytes(existingVal, newVal []byte) []byte {
+	out := make([]byte, 0, len(existingVal)+len(newVal))
+	out = append(out, existingVal...)
+	out = append(out, newVal...)
+	return out
+}
+
+func decodeUint64(b []byte) uint64 {
+	if len(b) == 0 {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
+
+func encodeUint64(v uint64) []byte {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, v)
+	return buf
+}
+
+func mergeUint64Add(existingVal, newVal []byte) []byte {
+	return encodeUint64(decodeUint64(existingVal) + decodeUint64(newVal))
+}
+
+func mergeUint64Max(existingVal, newVal []byte) []byte {
+	if decodeUint64(newVal) > decodeUint64(existingVal) {
+		return encodeUint64(decodeUint64(newVal))
+	}
+	return encodeUint64(decodeUint64(existingVal))
+}
+
+func mergeUint64Min(existingVal, newVal []byte) []byte {
+	if len(existingVal) == 0 {
+		return encodeUint64(decodeUint64(newVal))
+	}
+	if decodeUint64(newVal) < decodeUint64(existingVal) {
+		return encodeUint64(decodeUint64(newVal))
+	}
+	return encodeUint64(decodeUint64(existingVal))
+}
+
+// decodeLengthPrefixedItems splits a buffer of 4-byte-length-prefixed items,
+// as used by the set-union merge reducer, into individual item slices.
+func decodeLengthPrefixedItems(b []byte) [][]byte {
+	var items [][]byte
+	offset := 0
+	for offset+4 <= len(b) {
+		itemLen := int(binary.LittleEndian.Uint32(b[offset : offset+4]))
+		offset += 4
+		if offset+itemLen > len(b) {
+			break
+		}
+		items = append(items, b[offset:offset+itemLen])
+		offset += itemLen
+	}
+	return items
+}
+
+func mergeSetUnion(existingVal, newVal []byte) []byte {
+	seen := make(map[string]struct{})
+	var out []byte
+	for _, items := range [][][]byte{decodeLengthPrefixedItems(existingVal), decodeLengthPrefixedItems(newVal)} {
+		for _, item := range items {
+			if _, ok := seen[string(item)]; ok {
+				continue
+			}
+			seen[string(item)] = struct{}{}
+			out = appendLengthPrefixed(out, item)
+		}
+	}
+	return out
+}
+
+func appendLengthPrefixed(buf []byte, item []byte) []byte {
+	var tmp [4]byte
+	binary.LittleEndian.PutUint32(tmp[:], uint32(len(item)))
+	buf = append(buf, tmp[:]...)
+	buf = append(buf, item...)
+	return buf
+}
+
+// openDB opens a DB with the given badger.Options and stores its handle;
+// it's the shared tail end of both Open and OpenWithOptions. managed must
+// be true for a DB that TxnSetReadTs/TxnCommitTs will be used against,
+// since badger panics if those are called on a non-managed DB.
+func openDB(opts badger.Options, managed bool) (uintptr, error) {
+	var db *badger.DB
+	var err error
+	if managed {
+		db, err = badger.OpenManaged(opts)
+	} else {
+		db, err = badger.Open(opts)
+	}
+	if err != nil {
+		return 0, err
+	}
+	return storeHandle(db, managed), nil
+}
+
+// openOptions is the JSON payload accepted by OpenWithOptions. Every field
+// is optional; omitted fields fall back to badger's own defaults.
+type openOptions struct {
+	InMemory                      bool   `json:"inMemory"`
+	ValueLogFileSize              int64  `json:"valueLogFileSize"`
+	NumVersionsToKeep             int    `json:"numVersionsToKeep"`
+	Compression                   string `json:"compression"` // "none", "snappy" or "zstd"
+	CompressionLevel              int    `json:"compressionLevel"`
+	BlockCacheSize                int64  `json:"blockCacheSize"`
+	IndexCacheSize                int64  `json:"indexCacheSize"`
+	DetectConflicts               *bool  `json:"detectConflicts"`
+	SyncWrites                    bool   `json:"syncWrites"`
+	EncryptionKey                 string `json:"encryptionKey"`                 // base64-encoded AES key (16/24/32 bytes)
+	EncryptionKeyRotationDuration string `json:"encryptionKeyRotationDuration"` // e.g. "240h"
+	// Managed opens the DB in badger's managed-transaction mode, which
+	// TxnSetReadTs/TxnCommitTs require.
+	Managed bool `json:"managed"`
+}
+
+func compressionFromString(s string) (badgeroptions.CompressionType, error) {
+	switch s {
+	case "", "none":
+		return badgeroptions.None, nil
+	case "snappy":
+		return badgeroptions.Snappy, nil
+	case "zstd":
+		return badgeroptions.ZSTD, nil
+	default:
+		return 0, errors.New("unknown compression type: " + s)
 	}
+}
 
+func buildOptions(goPath string, req openOptions) (badger.Options, error) {
 	var opts badger.Options
-	if goPath == "" {
+	if goPath == "" || req.InMemory {
 		opts = badger.DefaultOptions("").WithInMemory(true)
 	} else {
 		_ = os.MkdirAll(goPath, 0o755)
 		opts = badger.DefaultOptions(goPath)
 	}
 
-	db, err := badger.Open(opts)
+	if req.ValueLogFileSize > 0 {
+		opts = opts.WithValueLogFileSize(req.ValueLogFileSize)
+	}
+	if req.NumVersionsToKeep > 0 {
+		opts = opts.WithNumVersionsToKeep(req.NumVersionsToKeep)
+	}
+	if req.Compression != "" {
+		ctype, err := compressionFromString(req.Compression)
+		if err != nil {
+			return badger.Options{}, err
+		}
+		opts = opts.WithCompression(ctype)
+		if req.CompressionLevel > 0 {
+			opts = opts.WithZSTDCompressionLevel(req.CompressionLevel)
+		}
+	}
+	if req.BlockCacheSize > 0 {
+		opts = opts.WithBlockCacheSize(req.BlockCacheSize)
+	}
+	if req.IndexCacheSize > 0 {
+		opts = opts.WithIndexCacheSize(req.IndexCacheSize)
+	}
+	if req.DetectConflicts != nil {
+		opts = opts.WithDetectConflicts(*req.DetectConflicts)
+	}
+	opts = opts.WithSyncWrites(req.SyncWrites)
+
+	if req.EncryptionKey != "" {
+		key, err := base64.StdEncoding.DecodeString(req.EncryptionKey)
+		if err != nil {
+			return badger.Options{}, errors.New("encryptionKey must be base64-encoded: " + err.Error())
+		}
+		opts = opts.WithEncryptionKey(key)
+
+		rotation := 10 * 24 * time.Hour
+		if req.EncryptionKeyRotationDuration != "" {
+			rotation, err = time.ParseDuration(req.EncryptionKeyRotationDuration)
+			if err != nil {
+				return badger.Options{}, errors.New("invalid encryptionKeyRotationDuration: " + err.Error())
+			}
+		}
+		opts = opts.WithEncryptionKeyRotationDuration(rotation)
+	}
+
+	return opts, nil
+}
+
+//export Open
+func Open(path *C.char, inMemory C.int) C.uintptr_t {
+	opts, err := buildOptions(C.GoString(path), openOptions{InMemory: inMemory != 0})
+	if err != nil {
+		setError(err)
+		return 0
+	}
+
+	id, err := openDB(opts, false)
+	if err != nil {
+		setError(err)
+		return 0
+	}
+	setError(nil)
+	return C.uintptr_t(id)
+}
+
+//export OpenWithOptions
+func OpenWithOptions(path *C.char, optsJSON *C.char) C.uintptr_t {
+	var req openOptions
+	if jsonStr := C.GoString(optsJSON); jsonStr != "" {
+		if err := json.Unmarshal([]byte(jsonStr), &req); err != nil {
+			setError(err)
+			return 0
+		}
+	}
+
+	opts, err := buildOptions(C.GoString(path), req)
 	if err != nil {
 		setError(err)
 		return 0
 	}
 
+	id, err := openDB(opts, req.Managed)
+	if err != nil {
+		setError(err)
+		return 0
+	}
 	setError(nil)
-	return C.uintptr_t(storeHandle(db))
+	return C.uintptr_t(id)
+}
+
+// closeDB is the pure-Go core of Close, kept separate so Go tests can tear
+// down a DB without a cgo boundary in the way.
+func closeDB(dbHandle uintptr) error {
+	db, err := getHandle(dbHandle)
+	if err != nil {
+		return err
+	}
+	closeItersForHandle(dbHandle)
+	closeSnapsForHandle(dbHandle)
+	closeTxnsForHandle(dbHandle)
+	closeMergesForHandle(dbHandle)
+	if err := db.Close(); err != nil {
+		return err
+	}
+	deleteHandle(dbHandle)
+	return nil
 }
 
 //export Close
 func Close(handle C.uintptr_t) C.int {
-	db, err := getHandle(uintptr(handle))
+	return setError(closeDB(uintptr(handle)))
+}
+
+// setKV is the pure-Go core of Set, kept separate so Go tests can exercise
+// it without a cgo boundary in the way.
+func setKV(dbHandle uintptr, key, value []byte) error {
+	db, err := getHandle(dbHandle)
 	if err != nil {
-		return setError(err)
+		return err
 	}
-	if err := db.Close(); err != nil {
-		return setError(err)
+	if err := requireUnmanagedHandle(dbHandle, "Set"); err != nil {
+		return err
 	}
-	deleteHandle(uintptr(handle))
-	return setError(nil)
+	return db.Update(func(txn *badger.Txn) error {
+		return txn.Set(key, value)
+	})
 }
 
 //export Set
 func Set(handle C.uintptr_t, key *C.char, keyLen C.int, value *C.char, valueLen C.int) C.int {
-	db, err := getHandle(uintptr(handle))
-	if err != nil {
-		return setError(err)
-	}
 	gotKey := C.GoBytes(unsafe.Pointer(key), keyLen)
 	gotValue := C.GoBytes(unsafe.Pointer(value), valueLen)
-	err = db.Update(func(txn *badger.Txn) error {
-		return txn.Set(gotKey, gotValue)
-	})
-	return setError(err)
+	return setError(setKV(uintptr(handle), gotKey, gotValue))
 }
 
 //export Get
@@ -161,54 +669,228 @@ func Get(handle C.uintptr_t, key *C.char, keyLen C.int, valueLen *C.int) *C.char
 	return (*C.char)(buf)
 }
 
-//export Delete
-func Delete(handle C.uintptr_t, key *C.char, keyLen C.int) C.int {
-	db, err := getHandle(uintptr(handle))
+// setWithTTL is the pure-Go core of SetWithTTL.
+func setWithTTL(dbHandle uintptr, key, value []byte, ttlSeconds int64, userMeta byte) error {
+	db, err := getHandle(dbHandle)
 	if err != nil {
-		return setError(err)
+		return err
 	}
-	gotKey := C.GoBytes(unsafe.Pointer(key), keyLen)
-	err = db.Update(func(txn *badger.Txn) error {
-		return txn.Delete(gotKey)
+	if err := requireUnmanagedHandle(dbHandle, "SetWithTTL"); err != nil {
+		return err
+	}
+
+	entry := badger.NewEntry(key, value).WithMeta(userMeta)
+	if ttlSeconds > 0 {
+		entry = entry.WithTTL(time.Duration(ttlSeconds) * time.Second)
+	}
+
+	return db.Update(func(txn *badger.Txn) error {
+		return txn.SetEntry(entry)
 	})
-	return setError(err)
 }
 
-//export Sync
-func Sync(handle C.uintptr_t) C.int {
-	db, err := getHandle(uintptr(handle))
+//export SetWithTTL
+func SetWithTTL(handle C.uintptr_t, key *C.char, keyLen C.int, value *C.char, valueLen C.int, ttlSeconds C.int64_t, userMeta C.uint8_t) C.int {
+	gotKey := C.GoBytes(unsafe.Pointer(key), keyLen)
+	gotValue := C.GoBytes(unsafe.Pointer(value), valueLen)
+	return setError(setWithTTL(uintptr(handle), gotKey, gotValue, int64(ttlSeconds), byte(userMeta)))
+}
+
+// getWithMeta is the pure-Go core of GetWithMeta.
+func getWithMeta(dbHandle uintptr, key []byte) (value []byte, expiresAt uint64, userMeta byte, err error) {
+	db, err := getHandle(dbHandle)
 	if err != nil {
-		return setError(err)
+		return nil, 0, 0, err
 	}
-	return setError(db.Sync())
+
+	err = db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if err != nil {
+			return err
+		}
+		expiresAt = item.ExpiresAt()
+		userMeta = item.UserMeta()
+		return item.Value(func(val []byte) error {
+			value = append([]byte(nil), val...)
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return value, expiresAt, userMeta, nil
 }
 
-//export Scan
-func Scan(handle C.uintptr_t, prefix *C.char, prefixLen C.int, resultLen *C.int) *C.char {
-	db, err := getHandle(uintptr(handle))
+//export GetWithMeta
+func GetWithMeta(handle C.uintptr_t, key *C.char, keyLen C.int, valueLen *C.int, expiresAt *C.uint64_t, userMeta *C.uint8_t) *C.char {
+	gotKey := C.GoBytes(unsafe.Pointer(key), keyLen)
+	data, gotExpiresAt, gotUserMeta, err := getWithMeta(uintptr(handle), gotKey)
 	if err != nil {
 		setError(err)
 		return nil
 	}
+	if expiresAt != nil {
+		*expiresAt = C.uint64_t(gotExpiresAt)
+	}
+	if userMeta != nil {
+		*userMeta = C.uint8_t(gotUserMeta)
+	}
 
-	var pref []byte
-	if prefixLen > 0 {
-		pref = C.GoBytes(unsafe.Pointer(prefix), prefixLen)
+	size := len(data)
+	if size == 0 {
+		buf := C.malloc(1)
+		if buf == nil {
+			setError(errors.New("malloc failed"))
+			return nil
+		}
+		*valueLen = 0
+		setError(nil)
+		return (*C.char)(buf)
 	}
 
-	var buffer []byte
-	err = db.View(func(txn *badger.Txn) error {
-		opts := badger.DefaultIteratorOptions
-		opts.PrefetchValues = true
-		it := txn.NewIterator(opts)
-		defer it.Close()
+	buf := C.malloc(C.size_t(size))
+	if buf == nil {
+		setError(errors.New("malloc failed"))
+		return nil
+	}
 
-		if len(pref) > 0 {
-			for it.Seek(pref); it.ValidForPrefix(pref); it.Next() {
-				item := it.Item()
-				k := item.KeyCopy(nil)
-				if err := item.Value(func(val []byte) error {
-					buffer = appendEntry(buffer, k, val)
+	copy(((*[1 << 30]byte)(unsafe.Pointer(buf)))[:size:size], data)
+	*valueLen = C.int(size)
+	setError(nil)
+	return (*C.char)(buf)
+}
+
+// deleteKV is the pure-Go core of Delete.
+func deleteKV(dbHandle uintptr, key []byte) error {
+	db, err := getHandle(dbHandle)
+	if err != nil {
+		return err
+	}
+	if err := requireUnmanagedHandle(dbHandle, "Delete"); err != nil {
+		return err
+	}
+	return db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(key)
+	})
+}
+
+//export Delete
+func Delete(handle C.uintptr_t, key *C.char, keyLen C.int) C.int {
+	gotKey := C.GoBytes(unsafe.Pointer(key), keyLen)
+	return setError(deleteKV(uintptr(handle), gotKey))
+}
+
+//export Sync
+func Sync(handle C.uintptr_t) C.int {
+	db, err := getHandle(uintptr(handle))
+	if err != nil {
+		return setError(err)
+	}
+	return setError(db.Sync())
+}
+
+// runValueLogGC is the pure-Go core of RunValueLogGC. It returns rewritten
+// = true if a value-log file was rewritten, or false (with no error) if GC
+// ran but found nothing worth rewriting (badger.ErrNoRewrite).
+func runValueLogGC(dbHandle uintptr, discardRatio float64) (rewritten bool, err error) {
+	db, err := getHandle(dbHandle)
+	if err != nil {
+		return false, err
+	}
+	if err := db.RunValueLogGC(discardRatio); err != nil {
+		if errors.Is(err, badger.ErrNoRewrite) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// RunValueLogGC returns 0 if a value-log file was rewritten, 1 if GC ran
+// but found nothing worth rewriting (badger.ErrNoRewrite), or -1 on error.
+//
+//export RunValueLogGC
+func RunValueLogGC(handle C.uintptr_t, discardRatio C.double) C.int {
+	rewritten, err := runValueLogGC(uintptr(handle), float64(discardRatio))
+	if err != nil {
+		return setError(err)
+	}
+	setError(nil)
+	if rewritten {
+		return 0
+	}
+	return 1
+}
+
+// flattenDB is the pure-Go core of Flatten.
+func flattenDB(dbHandle uintptr, workers int) error {
+	db, err := getHandle(dbHandle)
+	if err != nil {
+		return err
+	}
+	return db.Flatten(workers)
+}
+
+//export Flatten
+func Flatten(handle C.uintptr_t, workers C.int) C.int {
+	return setError(flattenDB(uintptr(handle), int(workers)))
+}
+
+// dropPrefixDB is the pure-Go core of DropPrefix.
+func dropPrefixDB(dbHandle uintptr, prefix []byte) error {
+	db, err := getHandle(dbHandle)
+	if err != nil {
+		return err
+	}
+	return db.DropPrefix(prefix)
+}
+
+//export DropPrefix
+func DropPrefix(handle C.uintptr_t, prefix *C.char, prefixLen C.int) C.int {
+	gotPrefix := C.GoBytes(unsafe.Pointer(prefix), prefixLen)
+	return setError(dropPrefixDB(uintptr(handle), gotPrefix))
+}
+
+// dropAllDB is the pure-Go core of DropAll.
+func dropAllDB(dbHandle uintptr) error {
+	db, err := getHandle(dbHandle)
+	if err != nil {
+		return err
+	}
+	return db.DropAll()
+}
+
+//export DropAll
+func DropAll(handle C.uintptr_t) C.int {
+	return setError(dropAllDB(uintptr(handle)))
+}
+
+//export Scan
+func Scan(handle C.uintptr_t, prefix *C.char, prefixLen C.int, resultLen *C.int) *C.char {
+	db, err := getHandle(uintptr(handle))
+	if err != nil {
+		setError(err)
+		return nil
+	}
+
+	var pref []byte
+	if prefixLen > 0 {
+		pref = C.GoBytes(unsafe.Pointer(prefix), prefixLen)
+	}
+
+	var buffer []byte
+	err = db.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = true
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		if len(pref) > 0 {
+			for it.Seek(pref); it.ValidForPrefix(pref); it.Next() {
+				item := it.Item()
+				k := item.KeyCopy(nil)
+				if err := item.Value(func(val []byte) error {
+					buffer = appendEntry(buffer, k, val)
 					return nil
 				}); err != nil {
 					return err
@@ -262,16 +944,713 @@ func appendEntry(buf []byte, key, value []byte) []byte {
 	return buf
 }
 
-//export Apply
-func Apply(handle C.uintptr_t, ops *C.char, opsLen C.int) C.int {
-	db, err := getHandle(uintptr(handle))
+// iterOpen is the pure-Go core of IterOpen, kept separate so it's callable
+// straight from Go tests without a cgo boundary in the way.
+func iterOpen(dbHandle uintptr, prefix []byte, reverse, keysOnly bool) (uintptr, error) {
+	db, err := getHandle(dbHandle)
+	if err != nil {
+		return 0, err
+	}
+	// db.NewTransaction always tries to assign a read timestamp from the
+	// oracle, which badger forbids on a managed DB, so guard the same way
+	// TxnBegin does instead of letting it panic.
+	if err := requireUnmanagedHandle(dbHandle, "IterOpen"); err != nil {
+		return 0, err
+	}
+
+	txn := db.NewTransaction(false)
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = !keysOnly
+	opts.Reverse = reverse
+	opts.Prefix = prefix
+	it := txn.NewIterator(opts)
+
+	switch {
+	case len(prefix) > 0 && opts.Reverse:
+		// Reverse iteration over a prefix has to start from the largest
+		// possible key with that prefix; Seek walks backwards from there.
+		it.Seek(append(append([]byte{}, prefix...), 0xFF))
+	case len(prefix) > 0:
+		it.Seek(prefix)
+	default:
+		it.Rewind()
+	}
+
+	ih := &iterHandle{
+		dbHandle: dbHandle,
+		txn:      txn,
+		it:       it,
+		prefix:   prefix,
+		reverse:  opts.Reverse,
+		keysOnly: keysOnly,
+	}
+	return storeIter(ih), nil
+}
+
+func iterSeek(iterID uintptr, key []byte) error {
+	ih, err := getIter(iterID)
+	if err != nil {
+		return err
+	}
+	ih.it.Seek(key)
+	return nil
+}
+
+func iterSetUpperBound(iterID uintptr, key []byte) error {
+	ih, err := getIter(iterID)
+	if err != nil {
+		return err
+	}
+	ih.upperBound = key
+	return nil
+}
+
+// iterNext reports ok=false once the iterator is exhausted; val is nil for
+// a keys-only iterator.
+func iterNext(iterID uintptr) (key, val []byte, ok bool, err error) {
+	ih, err := getIter(iterID)
+	if err != nil {
+		return nil, nil, false, err
+	}
+
+	valid := ih.it.Valid()
+	if valid && len(ih.prefix) > 0 {
+		valid = ih.it.ValidForPrefix(ih.prefix)
+	}
+	if valid && len(ih.upperBound) > 0 {
+		item := ih.it.Item()
+		cmp := bytes.Compare(item.KeyCopy(nil), ih.upperBound)
+		if (!ih.reverse && cmp >= 0) || (ih.reverse && cmp <= 0) {
+			valid = false
+		}
+	}
+	if !valid {
+		return nil, nil, false, nil
+	}
+
+	item := ih.it.Item()
+	k := item.KeyCopy(nil)
+
+	var v []byte
+	if !ih.keysOnly {
+		if err := item.Value(func(val []byte) error {
+			v = append([]byte(nil), val...)
+			return nil
+		}); err != nil {
+			return nil, nil, false, err
+		}
+	}
+
+	ih.it.Next()
+	return k, v, true, nil
+}
+
+func iterClose(iterID uintptr) error {
+	ih, err := getIter(iterID)
+	if err != nil {
+		return err
+	}
+	ih.it.Close()
+	ih.txn.Discard()
+	deleteIter(iterID)
+	return nil
+}
+
+//export IterOpen
+func IterOpen(handle C.uintptr_t, prefix *C.char, prefixLen C.int, reverse C.int, keysOnly C.int) C.uintptr_t {
+	var pref []byte
+	if prefixLen > 0 {
+		pref = C.GoBytes(unsafe.Pointer(prefix), prefixLen)
+	}
+	id, err := iterOpen(uintptr(handle), pref, reverse != 0, keysOnly != 0)
+	if err != nil {
+		setError(err)
+		return 0
+	}
+	setError(nil)
+	return C.uintptr_t(id)
+}
+
+//export IterSeek
+func IterSeek(iterID C.uintptr_t, key *C.char, keyLen C.int) C.int {
+	gotKey := C.GoBytes(unsafe.Pointer(key), keyLen)
+	return setError(iterSeek(uintptr(iterID), gotKey))
+}
+
+//export IterUpperBound
+func IterUpperBound(iterID C.uintptr_t, key *C.char, keyLen C.int) C.int {
+	gotKey := C.GoBytes(unsafe.Pointer(key), keyLen)
+	return setError(iterSetUpperBound(uintptr(iterID), gotKey))
+}
+
+// IterNext returns 1 and fills keyOut/valOut when an entry is produced, 0
+// once the iterator is exhausted, or -1 (see LastError) on failure.
+//
+//export IterNext
+func IterNext(iterID C.uintptr_t, keyOut **C.char, keyLenOut *C.int, valOut **C.char, valLenOut *C.int) C.int {
+	k, v, ok, err := iterNext(uintptr(iterID))
+	if err != nil {
+		setError(err)
+		return -1
+	}
+	if !ok {
+		setError(nil)
+		return 0
+	}
+
+	keyBuf := C.malloc(C.size_t(len(k)))
+	if keyBuf == nil {
+		setError(errors.New("malloc failed"))
+		return -1
+	}
+	if len(k) > 0 {
+		copy(((*[1 << 30]byte)(unsafe.Pointer(keyBuf)))[:len(k):len(k)], k)
+	}
+	*keyOut = (*C.char)(keyBuf)
+	*keyLenOut = C.int(len(k))
+
+	if v == nil {
+		*valOut = nil
+		*valLenOut = 0
+	} else {
+		valBuf := C.malloc(C.size_t(len(v)))
+		if valBuf == nil {
+			C.free(keyBuf)
+			setError(errors.New("malloc failed"))
+			return -1
+		}
+		if len(v) > 0 {
+			copy(((*[1 << 30]byte)(unsafe.Pointer(valBuf)))[:len(v):len(v)], v)
+		}
+		*valOut = (*C.char)(valBuf)
+		*valLenOut = C.int(len(v))
+	}
+
+	setError(nil)
+	return 1
+}
+
+//export IterClose
+func IterClose(iterID C.uintptr_t) C.int {
+	return setError(iterClose(uintptr(iterID)))
+}
+
+func snapshotOpen(dbHandle uintptr) (uintptr, error) {
+	db, err := getHandle(dbHandle)
+	if err != nil {
+		return 0, err
+	}
+	if err := requireUnmanagedHandle(dbHandle, "SnapshotOpen"); err != nil {
+		return 0, err
+	}
+	sh := &snapHandle{dbHandle: dbHandle, txn: db.NewTransaction(false)}
+	return storeSnap(sh), nil
+}
+
+func snapshotClose(snapID uintptr) error {
+	sh, err := getSnap(snapID)
+	if err != nil {
+		return err
+	}
+	sh.txn.Discard()
+	deleteSnap(snapID)
+	return nil
+}
+
+func getAt(snapID uintptr, key []byte) ([]byte, error) {
+	sh, err := getSnap(snapID)
+	if err != nil {
+		return nil, err
+	}
+
+	item, err := sh.txn.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	if err := item.Value(func(val []byte) error {
+		data = append([]byte(nil), val...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// scanAt returns the matching entries length-prefix-encoded in the same
+// wire format as appendEntry/Scan, ready for the C caller to walk.
+func scanAt(snapID uintptr, prefix []byte) ([]byte, error) {
+	sh, err := getSnap(snapID)
+	if err != nil {
+		return nil, err
+	}
+
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = true
+	it := sh.txn.NewIterator(opts)
+	defer it.Close()
+
+	var buffer []byte
+	appendAll := func(seek []byte, validFn func() bool) error {
+		for it.Seek(seek); validFn(); it.Next() {
+			item := it.Item()
+			k := item.KeyCopy(nil)
+			if err := item.Value(func(val []byte) error {
+				buffer = appendEntry(buffer, k, val)
+				return nil
+			}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if len(prefix) > 0 {
+		err = appendAll(prefix, func() bool { return it.ValidForPrefix(prefix) })
+	} else {
+		err = appendAll(nil, it.Valid)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buffer, nil
+}
+
+//export SnapshotOpen
+func SnapshotOpen(handle C.uintptr_t) C.uintptr_t {
+	id, err := snapshotOpen(uintptr(handle))
+	if err != nil {
+		setError(err)
+		return 0
+	}
+	setError(nil)
+	return C.uintptr_t(id)
+}
+
+//export SnapshotClose
+func SnapshotClose(snapID C.uintptr_t) C.int {
+	return setError(snapshotClose(uintptr(snapID)))
+}
+
+//export GetAt
+func GetAt(snapID C.uintptr_t, key *C.char, keyLen C.int, valueLen *C.int) *C.char {
+	gotKey := C.GoBytes(unsafe.Pointer(key), keyLen)
+	data, err := getAt(uintptr(snapID), gotKey)
+	if err != nil {
+		setError(err)
+		return nil
+	}
+
+	size := len(data)
+	if size == 0 {
+		buf := C.malloc(1)
+		if buf == nil {
+			setError(errors.New("malloc failed"))
+			return nil
+		}
+		*valueLen = 0
+		setError(nil)
+		return (*C.char)(buf)
+	}
+
+	buf := C.malloc(C.size_t(size))
+	if buf == nil {
+		setError(errors.New("malloc failed"))
+		return nil
+	}
+
+	copy(((*[1 << 30]byte)(unsafe.Pointer(buf)))[:size:size], data)
+	*valueLen = C.int(size)
+	setError(nil)
+	return (*C.char)(buf)
+}
+
+//export ScanAt
+func ScanAt(snapID C.uintptr_t, prefix *C.char, prefixLen C.int, resultLen *C.int) *C.char {
+	var pref []byte
+	if prefixLen > 0 {
+		pref = C.GoBytes(unsafe.Pointer(prefix), prefixLen)
+	}
+
+	buffer, err := scanAt(uintptr(snapID), pref)
+	if err != nil {
+		setError(err)
+		return nil
+	}
+
+	if len(buffer) == 0 {
+		*resultLen = 0
+		setError(nil)
+		return nil
+	}
+
+	mem := C.malloc(C.size_t(len(buffer)))
+	if mem == nil {
+		setError(errors.New("malloc failed"))
+		return nil
+	}
+
+	copy(((*[1 << 30]byte)(unsafe.Pointer(mem)))[:len(buffer):len(buffer)], buffer)
+	*resultLen = C.int(len(buffer))
+	setError(nil)
+	return (*C.char)(mem)
+}
+
+// txnBegin is the pure-Go core of TxnBegin, kept separate so it's callable
+// straight from Go tests without a cgo boundary in the way.
+//
+// On a managed-mode DB, badger.DB.NewTransaction panics just as surely as
+// NewTransactionAt does on a non-managed one (it still tries to assign a
+// read timestamp from the oracle, which managed mode forbids). So for a
+// managed DB the underlying *badger.Txn is left unset here and only
+// created once TxnSetReadTs supplies the read timestamp explicitly.
+func txnBegin(dbHandle uintptr, update bool) (uintptr, error) {
+	db, err := getHandle(dbHandle)
+	if err != nil {
+		return 0, err
+	}
+	th := &txnHandle{
+		dbHandle: dbHandle,
+		db:       db,
+		update:   update,
+	}
+	if !isManagedHandle(dbHandle) {
+		th.txn = db.NewTransaction(update)
+	}
+	return storeTxn(th), nil
+}
+
+// txnSetReadTs requires the owning DB to have been opened in managed mode
+// (OpenWithOptions with "managed": true) — badger.DB.NewTransactionAt
+// panics otherwise, so that case is rejected here instead.
+func txnSetReadTs(txnID uintptr, readTs uint64) error {
+	th, err := getTxn(txnID)
+	if err != nil {
+		return err
+	}
+	if !isManagedHandle(th.dbHandle) {
+		return errors.New("TxnSetReadTs requires a DB opened with managed mode (OpenWithOptions \"managed\": true)")
+	}
+	if th.used {
+		return errors.New("TxnSetReadTs must be called before any read or write")
+	}
+	if th.txn != nil {
+		th.txn.Discard()
+	}
+	th.txn = th.db.NewTransactionAt(readTs, th.update)
+	return nil
+}
+
+// requireTxn reports an error instead of a nil-pointer panic when a
+// managed-mode txnHandle is used before TxnSetReadTs has given it a
+// *badger.Txn.
+func requireTxn(th *txnHandle) error {
+	if th.txn == nil {
+		return errors.New("transaction has no read timestamp yet; call TxnSetReadTs first on a managed-mode DB")
+	}
+	return nil
+}
+
+func txnSet(txnID uintptr, key, value []byte) error {
+	th, err := getTxn(txnID)
+	if err != nil {
+		return err
+	}
+	if err := requireTxn(th); err != nil {
+		return err
+	}
+	th.used = true
+	return th.txn.Set(key, value)
+}
+
+func txnGet(txnID uintptr, key []byte) ([]byte, error) {
+	th, err := getTxn(txnID)
+	if err != nil {
+		return nil, err
+	}
+	if err := requireTxn(th); err != nil {
+		return nil, err
+	}
+	th.used = true
+
+	item, err := th.txn.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	var data []byte
+	if err := item.Value(func(val []byte) error {
+		data = append([]byte(nil), val...)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+func txnDelete(txnID uintptr, key []byte) error {
+	th, err := getTxn(txnID)
+	if err != nil {
+		return err
+	}
+	if err := requireTxn(th); err != nil {
+		return err
+	}
+	th.used = true
+	return th.txn.Delete(key)
+}
+
+// txnCommit commits and, regardless of outcome, removes the handle:
+// badger.Txn.Commit discards the underlying txn on every path, including
+// ErrConflict, so a failed commit must be followed by a fresh txnBegin
+// rather than a retried txnCommit/txnDiscard on the same txnID.
+func txnCommit(txnID uintptr) error {
+	th, err := getTxn(txnID)
+	if err != nil {
+		return err
+	}
+	if err := requireTxn(th); err != nil {
+		return err
+	}
+	err = th.txn.Commit()
+	deleteTxn(txnID)
+	return err
+}
+
+// txnCommitTs commits at an explicit commit timestamp for external
+// managed-mode use, and like txnCommit requires a DB opened in managed
+// mode (badger.Txn.CommitAt panics otherwise); see txnCommit for the
+// handle-lifetime contract.
+func txnCommitTs(txnID uintptr, commitTs uint64) error {
+	th, err := getTxn(txnID)
+	if err != nil {
+		return err
+	}
+	if !isManagedHandle(th.dbHandle) {
+		return errors.New("TxnCommitTs requires a DB opened with managed mode (OpenWithOptions \"managed\": true)")
+	}
+	if err := requireTxn(th); err != nil {
+		return err
+	}
+	err = th.txn.CommitAt(commitTs, nil)
+	deleteTxn(txnID)
+	return err
+}
+
+func txnDiscard(txnID uintptr) error {
+	th, err := getTxn(txnID)
+	if err != nil {
+		return err
+	}
+	if th.txn != nil {
+		th.txn.Discard()
+	}
+	deleteTxn(txnID)
+	return nil
+}
+
+// commitErrCode maps a txnCommit/txnCommitTs result to the FFI return code:
+// 0 on success, -2 on a write conflict, -1 for any other error.
+func commitErrCode(err error) C.int {
+	if err != nil {
+		setError(err)
+		if errors.Is(err, badger.ErrConflict) {
+			return -2
+		}
+		return -1
+	}
+	return setError(nil)
+}
+
+//export TxnBegin
+func TxnBegin(handle C.uintptr_t, update C.int) C.uintptr_t {
+	id, err := txnBegin(uintptr(handle), update != 0)
+	if err != nil {
+		setError(err)
+		return 0
+	}
+	setError(nil)
+	return C.uintptr_t(id)
+}
+
+//export TxnSetReadTs
+func TxnSetReadTs(txnID C.uintptr_t, readTs C.uint64_t) C.int {
+	return setError(txnSetReadTs(uintptr(txnID), uint64(readTs)))
+}
+
+//export TxnSet
+func TxnSet(txnID C.uintptr_t, key *C.char, keyLen C.int, value *C.char, valueLen C.int) C.int {
+	gotKey := C.GoBytes(unsafe.Pointer(key), keyLen)
+	gotValue := C.GoBytes(unsafe.Pointer(value), valueLen)
+	return setError(txnSet(uintptr(txnID), gotKey, gotValue))
+}
+
+//export TxnGet
+func TxnGet(txnID C.uintptr_t, key *C.char, keyLen C.int, valueLen *C.int) *C.char {
+	gotKey := C.GoBytes(unsafe.Pointer(key), keyLen)
+	data, err := txnGet(uintptr(txnID), gotKey)
+	if err != nil {
+		setError(err)
+		return nil
+	}
+
+	size := len(data)
+	if size == 0 {
+		buf := C.malloc(1)
+		if buf == nil {
+			setError(errors.New("malloc failed"))
+			return nil
+		}
+		*valueLen = 0
+		setError(nil)
+		return (*C.char)(buf)
+	}
+
+	buf := C.malloc(C.size_t(size))
+	if buf == nil {
+		setError(errors.New("malloc failed"))
+		return nil
+	}
+
+	copy(((*[1 << 30]byte)(unsafe.Pointer(buf)))[:size:size], data)
+	*valueLen = C.int(size)
+	setError(nil)
+	return (*C.char)(buf)
+}
+
+//export TxnDelete
+func TxnDelete(txnID C.uintptr_t, key *C.char, keyLen C.int) C.int {
+	gotKey := C.GoBytes(unsafe.Pointer(key), keyLen)
+	return setError(txnDelete(uintptr(txnID), gotKey))
+}
+
+// TxnCommit returns 0 on success, -2 if the transaction lost a write
+// conflict (see badger.ErrConflict), and -1 for any other error.
+//
+//export TxnCommit
+func TxnCommit(txnID C.uintptr_t) C.int {
+	return commitErrCode(txnCommit(uintptr(txnID)))
+}
+
+// TxnCommitTs commits at an explicit commit timestamp for external
+// managed-mode use; see TxnCommit for the return code contract.
+//
+//export TxnCommitTs
+func TxnCommitTs(txnID C.uintptr_t, commitTs C.uint64_t) C.int {
+	return commitErrCode(txnCommitTs(uintptr(txnID), uint64(commitTs)))
+}
+
+//export TxnDiscard
+func TxnDiscard(txnID C.uintptr_t) C.int {
+	return setError(txnDiscard(uintptr(txnID)))
+}
+
+func mergeRegister(dbHandle uintptr, key []byte, opCode int) (uintptr, error) {
+	db, err := getHandle(dbHandle)
+	if err != nil {
+		return 0, err
+	}
+	// db.GetMergeOperator starts a background compaction goroutine that
+	// calls db.NewTransaction, which panics on a managed DB the same way
+	// Set/Delete/Apply do, so registering one is rejected up front.
+	if err := requireUnmanagedHandle(dbHandle, "MergeRegister"); err != nil {
+		return 0, err
+	}
+	fn, err := mergeFuncForOpCode(opCode)
+	if err != nil {
+		return 0, err
+	}
+
+	mh := &mergeHandle{
+		dbHandle: dbHandle,
+		op:       db.GetMergeOperator(key, fn, mergeCompactionInterval),
+	}
+	return storeMerge(mh), nil
+}
+
+func mergeAdd(mergeID uintptr, delta []byte) error {
+	mh, err := getMerge(mergeID)
+	if err != nil {
+		return err
+	}
+	// MergeOperator.Add calls db.Update internally, which panics on a
+	// managed DB the same way Set/Delete/Apply do.
+	if err := requireUnmanagedHandle(mh.dbHandle, "MergeAdd"); err != nil {
+		return err
+	}
+	return mh.op.Add(delta)
+}
+
+func mergeGet(mergeID uintptr) ([]byte, error) {
+	mh, err := getMerge(mergeID)
+	if err != nil {
+		return nil, err
+	}
+	return mh.op.Get()
+}
+
+func mergeStop(mergeID uintptr) error {
+	mh, err := getMerge(mergeID)
+	if err != nil {
+		return err
+	}
+	mh.op.Stop()
+	deleteMerge(mergeID)
+	return nil
+}
+
+//export MergeRegister
+func MergeRegister(handle C.uintptr_t, key *C.char, keyLen C.int, opCode C.int) C.uintptr_t {
+	gotKey := C.GoBytes(unsafe.Pointer(key), keyLen)
+	id, err := mergeRegister(uintptr(handle), gotKey, int(opCode))
+	if err != nil {
+		setError(err)
+		return 0
+	}
+	setError(nil)
+	return C.uintptr_t(id)
+}
+
+//export MergeAdd
+func MergeAdd(mergeID C.uintptr_t, delta *C.char, deltaLen C.int) C.int {
+	gotDelta := C.GoBytes(unsafe.Pointer(delta), deltaLen)
+	return setError(mergeAdd(uintptr(mergeID), gotDelta))
+}
+
+//export MergeGet
+func MergeGet(mergeID C.uintptr_t, valOut **C.char, valLenOut *C.int) C.int {
+	val, err := mergeGet(uintptr(mergeID))
 	if err != nil {
 		return setError(err)
 	}
 
-	data := C.GoBytes(unsafe.Pointer(ops), opsLen)
+	buf := C.malloc(C.size_t(len(val)))
+	if buf == nil {
+		return setError(errors.New("malloc failed"))
+	}
+	if len(val) > 0 {
+		copy(((*[1 << 30]byte)(unsafe.Pointer(buf)))[:len(val):len(val)], val)
+	}
+	*valOut = (*C.char)(buf)
+	*valLenOut = C.int(len(val))
+	return setError(nil)
+}
+
+//export MergeStop
+func MergeStop(mergeID C.uintptr_t) C.int {
+	return setError(mergeStop(uintptr(mergeID)))
+}
 
-	err = db.Update(func(txn *badger.Txn) error {
+// applyOps is the pure-Go core of Apply.
+func applyOps(dbHandle uintptr, data []byte) error {
+	db, err := getHandle(dbHandle)
+	if err != nil {
+		return err
+	}
+	if err := requireUnmanagedHandle(dbHandle, "Apply"); err != nil {
+		return err
+	}
+
+	return db.Update(func(txn *badger.Txn) error {
 		offset := 0
 		for offset < len(data) {
 			op := data[offset]
@@ -310,14 +1689,126 @@ func Apply(handle C.uintptr_t, ops *C.char, opsLen C.int) C.int {
 					}
 					return err
 				}
+			case 2: // set with TTL + user meta
+				if offset+8+1 > len(data) {
+					return errors.New("malformed operation ttl/meta")
+				}
+				ttlSeconds := binary.LittleEndian.Uint64(data[offset : offset+8])
+				offset += 8
+				meta := data[offset]
+				offset++
+
+				if offset+4 > len(data) {
+					return errors.New("malformed operation value length")
+				}
+				valLen := binary.LittleEndian.Uint32(data[offset : offset+4])
+				offset += 4
+				if offset+int(valLen) > len(data) {
+					return errors.New("malformed operation value")
+				}
+				val := data[offset : offset+int(valLen)]
+				offset += int(valLen)
+
+				entry := badger.NewEntry(key, val).WithMeta(meta)
+				if ttlSeconds > 0 {
+					entry = entry.WithTTL(time.Duration(ttlSeconds) * time.Second)
+				}
+				if err := txn.SetEntry(entry); err != nil {
+					return err
+				}
 			default:
 				return errors.New("unknown operation code")
 			}
 		}
 		return nil
 	})
+}
+
+//export Apply
+func Apply(handle C.uintptr_t, ops *C.char, opsLen C.int) C.int {
+	data := C.GoBytes(unsafe.Pointer(ops), opsLen)
+	return setError(applyOps(uintptr(handle), data))
+}
+
+// backupToWriter is the pure-Go core shared by Backup and StreamBackup, kept
+// separate so Go tests can drive it against a bytes.Buffer without a cgo
+// boundary in the way.
+func backupToWriter(dbHandle uintptr, w io.Writer, sinceVersion uint64) (uint64, error) {
+	db, err := getHandle(dbHandle)
+	if err != nil {
+		return 0, err
+	}
+	return db.Backup(w, sinceVersion)
+}
+
+//export Backup
+func Backup(handle C.uintptr_t, path *C.char, sinceVersion C.uint64_t, newSinceVersion *C.uint64_t) C.int {
+	f, err := os.Create(C.GoString(path))
+	if err != nil {
+		return setError(err)
+	}
+	defer f.Close()
+
+	next, err := backupToWriter(uintptr(handle), f, uint64(sinceVersion))
+	if err != nil {
+		return setError(err)
+	}
+	if newSinceVersion != nil {
+		*newSinceVersion = C.uint64_t(next)
+	}
+	return setError(nil)
+}
+
+// loadFromReader is the pure-Go core of Load.
+func loadFromReader(dbHandle uintptr, r io.Reader, maxPendingWrites int) error {
+	db, err := getHandle(dbHandle)
+	if err != nil {
+		return err
+	}
+	return db.Load(r, maxPendingWrites)
+}
+
+//export Load
+func Load(handle C.uintptr_t, path *C.char, maxPendingWrites C.int) C.int {
+	f, err := os.Open(C.GoString(path))
+	if err != nil {
+		return setError(err)
+	}
+	defer f.Close()
+
+	return setError(loadFromReader(uintptr(handle), f, int(maxPendingWrites)))
+}
+
+// streamWriter adapts a C backup_callback_t into an io.Writer so it can be
+// handed to badger.DB.Backup, which writes backup chunks as it produces them.
+type streamWriter struct {
+	cb       C.backup_callback_t
+	userData unsafe.Pointer
+}
+
+func (s *streamWriter) Write(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	C.call_backup_callback(s.cb, s.userData, (*C.char)(unsafe.Pointer(&p[0])), C.int(len(p)))
+	return len(p), nil
+}
 
-	return setError(err)
+//export StreamBackup
+func StreamBackup(handle C.uintptr_t, callback C.backup_callback_t, userData unsafe.Pointer, sinceVersion C.uint64_t, newSinceVersion *C.uint64_t) C.int {
+	if callback == nil {
+		return setError(errors.New("callback must not be null"))
+	}
+
+	w := &streamWriter{cb: callback, userData: userData}
+	next, err := backupToWriter(uintptr(handle), w, uint64(sinceVersion))
+	if err != nil {
+		return setError(err)
+	}
+	if newSinceVersion != nil {
+		*newSinceVersion = C.uint64_t(next)
+	}
+	return setError(nil)
 }
 
 //export LastError
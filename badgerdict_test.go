@@ -0,0 +1,552 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger/v4"
+	badgeroptions "github.com/dgraph-io/badger/v4/options"
+)
+
+// openTestDB opens an in-memory, non-managed DB and registers cleanup.
+func openTestDB(t *testing.T) uintptr {
+	t.Helper()
+	opts := badger.DefaultOptions("").WithInMemory(true).WithLoggingLevel(badger.ERROR)
+	id, err := openDB(opts, false)
+	if err != nil {
+		t.Fatalf("openDB: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := closeDB(id); err != nil {
+			t.Errorf("closeDB: %v", err)
+		}
+	})
+	return id
+}
+
+// openManagedTestDB opens an in-memory DB in managed mode.
+func openManagedTestDB(t *testing.T) uintptr {
+	t.Helper()
+	opts := badger.DefaultOptions("").WithInMemory(true).WithLoggingLevel(badger.ERROR)
+	id, err := openDB(opts, true)
+	if err != nil {
+		t.Fatalf("openDB: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := closeDB(id); err != nil {
+			t.Errorf("closeDB: %v", err)
+		}
+	})
+	return id
+}
+
+func TestTxnLifecycleCommit(t *testing.T) {
+	dbHandle := openTestDB(t)
+
+	txnID, err := txnBegin(dbHandle, true)
+	if err != nil {
+		t.Fatalf("txnBegin: %v", err)
+	}
+	if err := txnSet(txnID, []byte("k1"), []byte("v1")); err != nil {
+		t.Fatalf("txnSet: %v", err)
+	}
+	if err := txnCommit(txnID); err != nil {
+		t.Fatalf("txnCommit: %v", err)
+	}
+
+	// A committed txnID is discarded; a retry must fail rather than silently
+	// succeed, since badger.Txn.Commit already discarded it internally.
+	if err := txnCommit(txnID); err == nil {
+		t.Fatalf("txnCommit on an already-committed handle should fail")
+	}
+
+	// The write should now be visible from a fresh read-only transaction.
+	readID, err := txnBegin(dbHandle, false)
+	if err != nil {
+		t.Fatalf("txnBegin: %v", err)
+	}
+	val, err := txnGet(readID, []byte("k1"))
+	if err != nil {
+		t.Fatalf("txnGet: %v", err)
+	}
+	if string(val) != "v1" {
+		t.Fatalf("txnGet = %q, want %q", val, "v1")
+	}
+	if err := txnDiscard(readID); err != nil {
+		t.Fatalf("txnDiscard: %v", err)
+	}
+}
+
+func TestTxnDeleteAndDiscard(t *testing.T) {
+	dbHandle := openTestDB(t)
+
+	setupID, err := txnBegin(dbHandle, true)
+	if err != nil {
+		t.Fatalf("txnBegin: %v", err)
+	}
+	if err := txnSet(setupID, []byte("k2"), []byte("v2")); err != nil {
+		t.Fatalf("txnSet: %v", err)
+	}
+	if err := txnCommit(setupID); err != nil {
+		t.Fatalf("txnCommit: %v", err)
+	}
+
+	delID, err := txnBegin(dbHandle, true)
+	if err != nil {
+		t.Fatalf("txnBegin: %v", err)
+	}
+	if err := txnDelete(delID, []byte("k2")); err != nil {
+		t.Fatalf("txnDelete: %v", err)
+	}
+	// Discard instead of committing the delete.
+	if err := txnDiscard(delID); err != nil {
+		t.Fatalf("txnDiscard: %v", err)
+	}
+
+	// The delete was never committed, so the key must still be there.
+	readID, err := txnBegin(dbHandle, false)
+	if err != nil {
+		t.Fatalf("txnBegin: %v", err)
+	}
+	defer txnDiscard(readID)
+	val, err := txnGet(readID, []byte("k2"))
+	if err != nil {
+		t.Fatalf("txnGet after discarded delete: %v", err)
+	}
+	if string(val) != "v2" {
+		t.Fatalf("txnGet = %q, want %q", val, "v2")
+	}
+}
+
+func TestTxnBeginOnManagedDBRequiresReadTs(t *testing.T) {
+	dbHandle := openManagedTestDB(t)
+
+	txnID, err := txnBegin(dbHandle, true)
+	if err != nil {
+		t.Fatalf("txnBegin: %v", err)
+	}
+	defer txnDiscard(txnID)
+
+	// Using the txn before TxnSetReadTs must fail, not panic: badger.DB's
+	// NewTransaction can't assign a read timestamp on a managed DB.
+	if err := txnSet(txnID, []byte("k"), []byte("v")); err == nil {
+		t.Fatalf("txnSet before TxnSetReadTs on a managed DB should fail")
+	}
+	if _, err := txnGet(txnID, []byte("k")); err == nil {
+		t.Fatalf("txnGet before TxnSetReadTs on a managed DB should fail")
+	}
+	if err := txnCommit(txnID); err == nil {
+		t.Fatalf("txnCommit before TxnSetReadTs on a managed DB should fail")
+	}
+}
+
+func TestTxnSetReadTsRequiresManagedDB(t *testing.T) {
+	dbHandle := openTestDB(t)
+
+	txnID, err := txnBegin(dbHandle, true)
+	if err != nil {
+		t.Fatalf("txnBegin: %v", err)
+	}
+	defer txnDiscard(txnID)
+
+	if err := txnSetReadTs(txnID, 5); err == nil {
+		t.Fatalf("txnSetReadTs on a non-managed DB should fail instead of panicking")
+	}
+}
+
+func TestIteratorLifecycle(t *testing.T) {
+	dbHandle := openTestDB(t)
+
+	setupID, err := txnBegin(dbHandle, true)
+	if err != nil {
+		t.Fatalf("txnBegin: %v", err)
+	}
+	for _, kv := range [][2]string{{"a1", "1"}, {"a2", "2"}, {"b1", "3"}} {
+		if err := txnSet(setupID, []byte(kv[0]), []byte(kv[1])); err != nil {
+			t.Fatalf("txnSet: %v", err)
+		}
+	}
+	if err := txnCommit(setupID); err != nil {
+		t.Fatalf("txnCommit: %v", err)
+	}
+
+	iterID, err := iterOpen(dbHandle, []byte("a"), false, false)
+	if err != nil {
+		t.Fatalf("iterOpen: %v", err)
+	}
+
+	var got []string
+	for {
+		k, v, ok, err := iterNext(iterID)
+		if err != nil {
+			t.Fatalf("iterNext: %v", err)
+		}
+		if !ok {
+			break
+		}
+		got = append(got, string(k)+"="+string(v))
+	}
+	if len(got) != 2 {
+		t.Fatalf("iterator over prefix %q returned %v, want 2 entries", "a", got)
+	}
+
+	if err := iterClose(iterID); err != nil {
+		t.Fatalf("iterClose: %v", err)
+	}
+	// Using a closed iterator handle must fail, not panic.
+	if _, _, _, err := iterNext(iterID); err == nil {
+		t.Fatalf("iterNext on a closed iterator should fail")
+	}
+}
+
+func TestSnapshotLifecycle(t *testing.T) {
+	dbHandle := openTestDB(t)
+
+	setupID, err := txnBegin(dbHandle, true)
+	if err != nil {
+		t.Fatalf("txnBegin: %v", err)
+	}
+	if err := txnSet(setupID, []byte("s1"), []byte("one")); err != nil {
+		t.Fatalf("txnSet: %v", err)
+	}
+	if err := txnSet(setupID, []byte("s2"), []byte("two")); err != nil {
+		t.Fatalf("txnSet: %v", err)
+	}
+	if err := txnCommit(setupID); err != nil {
+		t.Fatalf("txnCommit: %v", err)
+	}
+
+	snapID, err := snapshotOpen(dbHandle)
+	if err != nil {
+		t.Fatalf("snapshotOpen: %v", err)
+	}
+
+	val, err := getAt(snapID, []byte("s1"))
+	if err != nil {
+		t.Fatalf("getAt: %v", err)
+	}
+	if string(val) != "one" {
+		t.Fatalf("getAt = %q, want %q", val, "one")
+	}
+
+	buf, err := scanAt(snapID, []byte("s"))
+	if err != nil {
+		t.Fatalf("scanAt: %v", err)
+	}
+	entries := decodeEntries(t, buf)
+	if len(entries) != 2 {
+		t.Fatalf("scanAt returned %d entries, want 2", len(entries))
+	}
+
+	if err := snapshotClose(snapID); err != nil {
+		t.Fatalf("snapshotClose: %v", err)
+	}
+	if _, err := getAt(snapID, []byte("s1")); err == nil {
+		t.Fatalf("getAt on a closed snapshot should fail")
+	}
+}
+
+// decodeEntries decodes the appendEntry wire format produced by scanAt.
+func decodeEntries(t *testing.T, buf []byte) map[string]string {
+	t.Helper()
+	entries := make(map[string]string)
+	for offset := 0; offset < len(buf); {
+		if offset+8 > len(buf) {
+			t.Fatalf("truncated scanAt buffer at offset %d", offset)
+		}
+		keyLen := int(binary.LittleEndian.Uint32(buf[offset : offset+4]))
+		valLen := int(binary.LittleEndian.Uint32(buf[offset+4 : offset+8]))
+		offset += 8
+		if offset+keyLen+valLen > len(buf) {
+			t.Fatalf("truncated scanAt entry at offset %d", offset)
+		}
+		key := buf[offset : offset+keyLen]
+		val := buf[offset+keyLen : offset+keyLen+valLen]
+		entries[string(key)] = string(val)
+		offset += keyLen + valLen
+	}
+	return entries
+}
+
+func TestMergeLifecycle(t *testing.T) {
+	dbHandle := openTestDB(t)
+
+	mergeID, err := mergeRegister(dbHandle, []byte("counter"), mergeOpUint64Add)
+	if err != nil {
+		t.Fatalf("mergeRegister: %v", err)
+	}
+
+	add := func(delta uint64) {
+		t.Helper()
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], delta)
+		if err := mergeAdd(mergeID, buf[:]); err != nil {
+			t.Fatalf("mergeAdd: %v", err)
+		}
+	}
+	add(1)
+	add(2)
+	add(3)
+
+	val, err := mergeGet(mergeID)
+	if err != nil {
+		t.Fatalf("mergeGet: %v", err)
+	}
+	if got := binary.LittleEndian.Uint64(val); got != 6 {
+		t.Fatalf("mergeGet = %d, want 6", got)
+	}
+
+	if err := mergeStop(mergeID); err != nil {
+		t.Fatalf("mergeStop: %v", err)
+	}
+	if _, err := mergeGet(mergeID); err == nil {
+		t.Fatalf("mergeGet after mergeStop should fail")
+	}
+}
+
+func TestBuildOptionsParsesCompressionAndEncryption(t *testing.T) {
+	opts, err := buildOptions("", openOptions{
+		InMemory:                      true,
+		Compression:                   "zstd",
+		CompressionLevel:              3,
+		EncryptionKey:                 base64.StdEncoding.EncodeToString(make([]byte, 32)),
+		EncryptionKeyRotationDuration: "1h",
+	})
+	if err != nil {
+		t.Fatalf("buildOptions: %v", err)
+	}
+	if opts.Compression != badgeroptions.ZSTD {
+		t.Fatalf("opts.Compression = %v, want ZSTD", opts.Compression)
+	}
+	if opts.EncryptionKeyRotationDuration != time.Hour {
+		t.Fatalf("opts.EncryptionKeyRotationDuration = %v, want 1h", opts.EncryptionKeyRotationDuration)
+	}
+	if len(opts.EncryptionKey) != 32 {
+		t.Fatalf("opts.EncryptionKey has length %d, want 32", len(opts.EncryptionKey))
+	}
+}
+
+func TestBuildOptionsRejectsBadEncryptionKey(t *testing.T) {
+	if _, err := buildOptions("", openOptions{InMemory: true, EncryptionKey: "not-base64!!"}); err == nil {
+		t.Fatalf("buildOptions with a non-base64 encryptionKey should fail")
+	}
+}
+
+func TestBuildOptionsRejectsUnknownCompression(t *testing.T) {
+	if _, err := buildOptions("", openOptions{InMemory: true, Compression: "lzma"}); err == nil {
+		t.Fatalf("buildOptions with an unknown compression type should fail")
+	}
+}
+
+func TestOpenDBManagedRejectsUnmanagedOnlyOps(t *testing.T) {
+	dbHandle := openManagedTestDB(t)
+
+	if err := setKV(dbHandle, []byte("k"), []byte("v")); err == nil {
+		t.Fatalf("setKV on a managed DB should fail instead of panicking")
+	}
+	if _, err := iterOpen(dbHandle, nil, false, false); err == nil {
+		t.Fatalf("iterOpen on a managed DB should fail instead of panicking")
+	}
+	if _, err := snapshotOpen(dbHandle); err == nil {
+		t.Fatalf("snapshotOpen on a managed DB should fail instead of panicking")
+	}
+	if _, err := mergeRegister(dbHandle, []byte("counter"), mergeOpUint64Add); err == nil {
+		t.Fatalf("mergeRegister on a managed DB should fail instead of panicking")
+	}
+}
+
+func TestDropPrefixAndDropAll(t *testing.T) {
+	dbHandle := openTestDB(t)
+
+	for _, kv := range [][2]string{{"p1", "1"}, {"p2", "2"}, {"q1", "3"}} {
+		if err := setKV(dbHandle, []byte(kv[0]), []byte(kv[1])); err != nil {
+			t.Fatalf("setKV: %v", err)
+		}
+	}
+
+	if err := dropPrefixDB(dbHandle, []byte("p")); err != nil {
+		t.Fatalf("dropPrefixDB: %v", err)
+	}
+	if _, _, _, err := getWithMeta(dbHandle, []byte("p1")); err == nil {
+		t.Fatalf("getWithMeta for a dropped-prefix key should fail")
+	}
+	if val, _, _, err := getWithMeta(dbHandle, []byte("q1")); err != nil || string(val) != "3" {
+		t.Fatalf("getWithMeta(q1) = %q, %v, want \"3\", nil", val, err)
+	}
+
+	if err := dropAllDB(dbHandle); err != nil {
+		t.Fatalf("dropAllDB: %v", err)
+	}
+	if _, _, _, err := getWithMeta(dbHandle, []byte("q1")); err == nil {
+		t.Fatalf("getWithMeta after DropAll should fail")
+	}
+}
+
+func TestFlattenAndRunValueLogGC(t *testing.T) {
+	// RunValueLogGC refuses to run against an in-memory DB, so this needs an
+	// on-disk one.
+	opts := badger.DefaultOptions(t.TempDir()).WithLoggingLevel(badger.ERROR)
+	dbHandle, err := openDB(opts, false)
+	if err != nil {
+		t.Fatalf("openDB: %v", err)
+	}
+	t.Cleanup(func() {
+		if err := closeDB(dbHandle); err != nil {
+			t.Errorf("closeDB: %v", err)
+		}
+	})
+
+	if err := setKV(dbHandle, []byte("f1"), []byte("v1")); err != nil {
+		t.Fatalf("setKV: %v", err)
+	}
+	if err := flattenDB(dbHandle, 1); err != nil {
+		t.Fatalf("flattenDB: %v", err)
+	}
+
+	// A freshly written DB has nothing worth rewriting yet, so GC should
+	// report "no rewrite" rather than an error.
+	rewritten, err := runValueLogGC(dbHandle, 0.5)
+	if err != nil {
+		t.Fatalf("runValueLogGC: %v", err)
+	}
+	if rewritten {
+		t.Fatalf("runValueLogGC on a freshly written DB reported a rewrite")
+	}
+}
+
+func TestMergeRegisterUnknownOpCode(t *testing.T) {
+	dbHandle := openTestDB(t)
+	if _, err := mergeRegister(dbHandle, []byte("bad"), 99); err == nil {
+		t.Fatalf("mergeRegister with an unknown opCode should fail")
+	}
+}
+
+func TestTxnCommitTsManagedLifecycle(t *testing.T) {
+	dbHandle := openManagedTestDB(t)
+
+	txnID, err := txnBegin(dbHandle, true)
+	if err != nil {
+		t.Fatalf("txnBegin: %v", err)
+	}
+	if err := txnSetReadTs(txnID, 1); err != nil {
+		t.Fatalf("txnSetReadTs: %v", err)
+	}
+	if err := txnSet(txnID, []byte("k3"), []byte("v3")); err != nil {
+		t.Fatalf("txnSet: %v", err)
+	}
+	if err := txnCommitTs(txnID, 2); err != nil {
+		t.Fatalf("txnCommitTs: %v", err)
+	}
+	// As with txnCommit, the handle must be gone after commit.
+	if err := txnCommitTs(txnID, 3); err == nil {
+		t.Fatalf("txnCommitTs on an already-committed handle should fail")
+	}
+}
+
+func TestBackupAndLoadRoundTrip(t *testing.T) {
+	srcHandle := openTestDB(t)
+
+	setupID, err := txnBegin(srcHandle, true)
+	if err != nil {
+		t.Fatalf("txnBegin: %v", err)
+	}
+	if err := txnSet(setupID, []byte("b1"), []byte("one")); err != nil {
+		t.Fatalf("txnSet: %v", err)
+	}
+	if err := txnSet(setupID, []byte("b2"), []byte("two")); err != nil {
+		t.Fatalf("txnSet: %v", err)
+	}
+	if err := txnCommit(setupID); err != nil {
+		t.Fatalf("txnCommit: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := backupToWriter(srcHandle, &buf, 0); err != nil {
+		t.Fatalf("backupToWriter: %v", err)
+	}
+	if buf.Len() == 0 {
+		t.Fatalf("backupToWriter produced an empty backup")
+	}
+
+	dstHandle := openTestDB(t)
+	if err := loadFromReader(dstHandle, bytes.NewReader(buf.Bytes()), 256); err != nil {
+		t.Fatalf("loadFromReader: %v", err)
+	}
+
+	readID, err := txnBegin(dstHandle, false)
+	if err != nil {
+		t.Fatalf("txnBegin: %v", err)
+	}
+	defer txnDiscard(readID)
+	val, err := txnGet(readID, []byte("b1"))
+	if err != nil {
+		t.Fatalf("txnGet: %v", err)
+	}
+	if string(val) != "one" {
+		t.Fatalf("txnGet = %q, want %q", val, "one")
+	}
+}
+
+func TestSetWithTTLAndGetWithMeta(t *testing.T) {
+	dbHandle := openTestDB(t)
+
+	if err := setWithTTL(dbHandle, []byte("t1"), []byte("v1"), 0, 7); err != nil {
+		t.Fatalf("setWithTTL: %v", err)
+	}
+
+	val, expiresAt, userMeta, err := getWithMeta(dbHandle, []byte("t1"))
+	if err != nil {
+		t.Fatalf("getWithMeta: %v", err)
+	}
+	if string(val) != "v1" {
+		t.Fatalf("getWithMeta value = %q, want %q", val, "v1")
+	}
+	if userMeta != 7 {
+		t.Fatalf("getWithMeta userMeta = %d, want 7", userMeta)
+	}
+	if expiresAt != 0 {
+		t.Fatalf("getWithMeta expiresAt = %d, want 0 (no TTL set)", expiresAt)
+	}
+}
+
+// encodeApplySetWithTTL builds the Apply wire-format payload for a single
+// op=2 (set with TTL + user meta) operation, matching applyOps' decoder.
+func encodeApplySetWithTTL(key, value []byte, ttlSeconds uint64, meta byte) []byte {
+	buf := make([]byte, 0, 1+4+len(key)+8+1+4+len(value))
+	buf = append(buf, 2)
+	var lenBuf [4]byte
+	binary.LittleEndian.PutUint32(lenBuf[:], uint32(len(key)))
+	buf = append(buf, lenBuf[:]...)
+	buf = append(buf, key...)
+	var ttlBuf [8]byte
+	binary.LittleEndian.PutUint64(ttlBuf[:], ttlSeconds)
+	buf = append(buf, ttlBuf[:]...)
+	buf = append(buf, meta)
+	binary.LittleEndian.PutUint32(lenBuf[:], uint32(len(value)))
+	buf = append(buf, lenBuf[:]...)
+	buf = append(buf, value...)
+	return buf
+}
+
+func TestApplySetWithTTLOpCode(t *testing.T) {
+	dbHandle := openTestDB(t)
+
+	ops := encodeApplySetWithTTL([]byte("a1"), []byte("av"), 0, 3)
+	if err := applyOps(dbHandle, ops); err != nil {
+		t.Fatalf("applyOps: %v", err)
+	}
+
+	val, _, userMeta, err := getWithMeta(dbHandle, []byte("a1"))
+	if err != nil {
+		t.Fatalf("getWithMeta: %v", err)
+	}
+	if string(val) != "av" {
+		t.Fatalf("getWithMeta value = %q, want %q", val, "av")
+	}
+	if userMeta != 3 {
+		t.Fatalf("getWithMeta userMeta = %d, want 3", userMeta)
+	}
+}